@@ -1,71 +1,30 @@
 package sike
 
-// Helpers
+import "math/bits"
 
-// uint128 representation
-type uint128 struct {
-	H, L uint64
-}
+// Helpers
+//
+// These used to be hand-rolled 32x32-limb double-word arithmetic; math/bits
+// lowers directly to ADCX/ADOX/MULX on amd64 (and the equivalent on arm64),
+// so there's no reason to keep reimplementing it here. fpMul/fpMontRdc/
+// fpAddRdc/fpSubRdc/fpMulRdc below, built on top of these, are the only
+// implementation of those functions in this package: no build-tagged
+// arith_amd64.s/arith_arm64.s assembly kernel exists yet, so there is no
+// separate hot path to select between.
 
 func addc64(cin, a, b uint64) (ret, cout uint64) {
-	ret = cin
-	ret = ret + a
-	if ret < a {
-		cout = 1
-	}
-	ret = ret + b
-	if ret < b {
-		cout = 1
-	}
-
+	var c0, c1 uint64
+	ret, c0 = bits.Add64(a, b, 0)
+	ret, c1 = bits.Add64(ret, cin, 0)
+	cout = c0 | c1
 	return
 }
 
 func subc64(bIn, a, b uint64) (ret, bOut uint64) {
-	tmp := a - bIn
-	if tmp > a {
-		bOut = 1
-	}
-	ret = tmp - b
-	if ret > tmp {
-		bOut = 1
-	}
-	return
-}
-
-func mul64(a, b uint64) (res uint128) {
-	var al, bl, ah, bh, albl, albh, ahbl, ahbh uint64
-	var res1, res2, res3 uint64
-	var carry, maskL, maskH, temp uint64
-
-	maskL = (^maskL) >> 32
-	maskH = ^maskL
-
-	al = a & maskL
-	ah = a >> 32
-	bl = b & maskL
-	bh = b >> 32
-
-	albl = al * bl
-	albh = al * bh
-	ahbl = ah * bl
-	ahbh = ah * bh
-	res.L = albl & maskL
-
-	res1 = albl >> 32
-	res2 = ahbl & maskL
-	res3 = albh & maskL
-	temp = res1 + res2 + res3
-	carry = temp >> 32
-	res.L ^= temp << 32
-
-	res1 = ahbl >> 32
-	res2 = albh >> 32
-	res3 = ahbh & maskL
-	temp = res1 + res2 + res3 + carry
-	res.H = temp & maskL
-	carry = temp & maskH
-	res.H ^= (ahbh & maskH) + carry
+	var b0, b1 uint64
+	ret, b0 = bits.Sub64(a, b, 0)
+	ret, b1 = bits.Sub64(ret, bIn, 0)
+	bOut = b0 | b1
 	return
 }
 
@@ -140,13 +99,13 @@ func fpSwapCond(x, y *Fp, mask uint8) {
 func fpMul(z *FpX2, x, y *Fp) {
 	var u, v, t uint64
 	var carry uint64
-	var uv uint128
+	var hi, lo uint64
 
 	for i := uint64(0); i < FP_WORDS; i++ {
 		for j := uint64(0); j <= i; j++ {
-			uv = mul64(x[j], y[i-j])
-			v, carry = addc64(0, uv.L, v)
-			u, carry = addc64(carry, uv.H, u)
+			hi, lo = bits.Mul64(x[j], y[i-j])
+			v, carry = addc64(0, lo, v)
+			u, carry = addc64(carry, hi, u)
 			t += carry
 		}
 		z[i] = v
@@ -157,9 +116,9 @@ func fpMul(z *FpX2, x, y *Fp) {
 
 	for i := FP_WORDS; i < (2*FP_WORDS)-1; i++ {
 		for j := i - FP_WORDS + 1; j < FP_WORDS; j++ {
-			uv = mul64(x[j], y[i-j])
-			v, carry = addc64(0, uv.L, v)
-			u, carry = addc64(carry, uv.H, u)
+			hi, lo = bits.Mul64(x[j], y[i-j])
+			v, carry = addc64(0, lo, v)
+			u, carry = addc64(carry, hi, u)
 			t += carry
 		}
 		z[i] = v
@@ -174,7 +133,7 @@ func fpMul(z *FpX2, x, y *Fp) {
 // with R=2^512. Destroys the input value.
 func fpMontRdc(z *Fp, x *FpX2) {
 	var carry, t, u, v uint64
-	var uv uint128
+	var hi, lo uint64
 	var count int
 
 	count = 3 // number of 0 digits in the least significat part of p503 + 1
@@ -182,9 +141,9 @@ func fpMontRdc(z *Fp, x *FpX2) {
 	for i := 0; i < FP_WORDS; i++ {
 		for j := 0; j < i; j++ {
 			if j < (i - count + 1) {
-				uv = mul64(z[j], p503p1[i-j])
-				v, carry = addc64(0, uv.L, v)
-				u, carry = addc64(carry, uv.H, u)
+				hi, lo = bits.Mul64(z[j], p503p1[i-j])
+				v, carry = addc64(0, lo, v)
+				u, carry = addc64(carry, hi, u)
 				t += carry
 			}
 		}
@@ -204,9 +163,9 @@ func fpMontRdc(z *Fp, x *FpX2) {
 		}
 		for j := i - FP_WORDS + 1; j < FP_WORDS; j++ {
 			if j < (FP_WORDS - count) {
-				uv = mul64(z[j], p503p1[i-j])
-				v, carry = addc64(0, uv.L, v)
-				u, carry = addc64(carry, uv.H, u)
+				hi, lo = bits.Mul64(z[j], p503p1[i-j])
+				v, carry = addc64(0, lo, v)
+				u, carry = addc64(carry, hi, u)
 				t += carry
 			}
 		}
@@ -404,6 +363,35 @@ func sqr(dest, x *Fp2) {
 	fpMontRdc(&dest.B, &ab2)           // = 2*a*b*R mod p
 }
 
+// invBatch computes dest[i] = 1/src[i] for every i using Montgomery's
+// simultaneous inversion trick: one p34-based inv() call plus roughly 3*N
+// multiplications, instead of N separate inv() calls. dest and src must have
+// the same length; they may not alias.
+//
+// publicKeyGenA/publicKeyGenB in sike.go normalize the P/Q/Q-P projective
+// points they produce (which PublicKey.Export and shared-secret derivation
+// then read back) through this instead of three separate inv() calls.
+func invBatch(dest, src []Fp2) {
+	if len(src) == 0 {
+		return
+	}
+
+	running := make([]Fp2, len(src))
+	running[0] = src[0]
+	for i := 1; i < len(src); i++ {
+		mul(&running[i], &running[i-1], &src[i])
+	}
+
+	var runningInv Fp2
+	inv(&runningInv, &running[len(running)-1])
+
+	for i := len(src) - 1; i > 0; i-- {
+		mul(&dest[i], &running[i-1], &runningInv)
+		mul(&runningInv, &runningInv, &src[i])
+	}
+	dest[0] = runningInv
+}
+
 // In case choice == 1, performs following swap in constant time:
 // 	xPx <-> xQx
 //	xPz <-> xQz
@@ -0,0 +1,48 @@
+package sike
+
+import "testing"
+
+// FuzzInvBatch checks that invBatch agrees, element by element, with
+// inverting each field element individually via inv(). Field elements come
+// from arbitrary fuzzer bytes run through convBytesToFp2, the same path
+// PublicKey.Import uses to turn wire bytes into Fp2 values.
+func FuzzInvBatch(f *testing.F) {
+	const n = 4
+	sz := 2 * Params.Bytelen
+
+	seed := make([]byte, n*sz)
+	seed[0] = 1
+	f.Add(seed)
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		if len(raw) < n*sz {
+			t.Skip()
+		}
+
+		var zero Fp2
+		src := make([]Fp2, n)
+		for i := 0; i < n; i++ {
+			convBytesToFp2(&Params, &src[i], raw[i*sz:(i+1)*sz])
+			if src[i] == zero {
+				t.Skip()
+			}
+		}
+
+		got := make([]Fp2, n)
+		invBatch(got, src)
+
+		for i := 0; i < n; i++ {
+			var want Fp2
+			inv(&want, &src[i])
+			// mul and inv leave results reduced only mod 2*p; reduce both
+			// to [0, p) so that equal field elements compare equal.
+			fpRdcP(&got[i].A)
+			fpRdcP(&got[i].B)
+			fpRdcP(&want.A)
+			fpRdcP(&want.B)
+			if got[i] != want {
+				t.Fatalf("invBatch[%d] = %+v, want %+v", i, got[i], want)
+			}
+		}
+	})
+}
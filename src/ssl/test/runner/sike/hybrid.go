@@ -0,0 +1,109 @@
+package sike
+
+import (
+	"crypto/ecdh"
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// hybridHKDFLabel binds the combined secret to this specific hybrid
+// construction, following the CECPQ2b design (TLS 1.3 key-share code point
+// 0xFE32): the wire secret is HKDF-Extract-then-Expand(SHA-256) over the
+// concatenated X25519 and SIKE shared secrets.
+const hybridHKDFLabel = "sike-x25519-hybrid-v1"
+
+// HybridPublicKeySize returns the size, in bytes, of a client hybrid key
+// share on parameter set ps: an X25519 public key followed by a SIKE
+// public key.
+func HybridPublicKeySize(ps *ParameterSet) int {
+	return 32 + ps.Params.PublicKeySize
+}
+
+// HybridCiphertextSize returns the size, in bytes, of a server hybrid
+// response on parameter set ps: an X25519 public key followed by a SIKE
+// KEM ciphertext.
+func HybridCiphertextSize(ps *ParameterSet) int {
+	return 32 + ps.Params.PublicKeySize + int(ps.Params.KemSize) + 8
+}
+
+// HybridEncapsulate is the server side of an X25519+SIKE hybrid key
+// exchange. peerShare is the client's wire-encoded hybrid key share
+// (x25519_pubkey(32) || sike_pubkey); it returns the wire-encoded server
+// response (x25519_pubkey(32) || sike_ciphertext) and the combined shared
+// secret.
+func HybridEncapsulate(rng io.Reader, ps *ParameterSet, peerShare []byte) (ctext, secret []byte, err error) {
+	if len(peerShare) != HybridPublicKeySize(ps) {
+		return nil, nil, errors.New("sidh: malformed hybrid key share")
+	}
+
+	curve := ecdh.X25519()
+	priv, err := curve.GenerateKey(rng)
+	if err != nil {
+		return nil, nil, err
+	}
+	peerX25519, err := curve.NewPublicKey(peerShare[:32])
+	if err != nil {
+		return nil, nil, err
+	}
+	x25519Secret, err := priv.ECDH(peerX25519)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pub := NewPublicKey(KeyVariant_SIKE, ps)
+	if err := pub.Import(peerShare[32:]); err != nil {
+		return nil, nil, err
+	}
+	sikeCtext, sikeSecret, err := Encapsulate(rng, pub)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctext = make([]byte, 32+len(sikeCtext))
+	copy(ctext, priv.PublicKey().Bytes())
+	copy(ctext[32:], sikeCtext)
+
+	return ctext, hybridCombine(x25519Secret, sikeSecret), nil
+}
+
+// HybridDecapsulate is the client side of an X25519+SIKE hybrid key
+// exchange. x25519Priv/sikePriv (with its matching sikePub) are the
+// client's hybrid keypair, whose public half was sent as the key share;
+// ctext is the server's wire-encoded response, as produced by
+// HybridEncapsulate.
+func HybridDecapsulate(x25519Priv *ecdh.PrivateKey, sikePriv *PrivateKey, sikePub *PublicKey, ctext []byte) ([]byte, error) {
+	if len(ctext) < 32 {
+		return nil, errors.New("sidh: malformed hybrid ciphertext")
+	}
+
+	peerX25519, err := ecdh.X25519().NewPublicKey(ctext[:32])
+	if err != nil {
+		return nil, err
+	}
+	x25519Secret, err := x25519Priv.ECDH(peerX25519)
+	if err != nil {
+		return nil, err
+	}
+
+	sikeSecret, err := Decapsulate(sikePriv, sikePub, ctext[32:])
+	if err != nil {
+		return nil, err
+	}
+
+	return hybridCombine(x25519Secret, sikeSecret), nil
+}
+
+// hybridCombine derives the wire secret from the two component secrets via
+// HKDF-Extract-then-Expand(SHA-256, hybridHKDFLabel).
+func hybridCombine(x25519Secret, sikeSecret []byte) []byte {
+	ikm := make([]byte, 0, len(x25519Secret)+len(sikeSecret))
+	ikm = append(ikm, x25519Secret...)
+	ikm = append(ikm, sikeSecret...)
+
+	out := make([]byte, sha256.Size)
+	io.ReadFull(hkdf.New(sha256.New, ikm, nil, []byte(hybridHKDFLabel)), out)
+	return out
+}
@@ -0,0 +1,92 @@
+// Package hybrid wraps the X25519+SIKE hybrid KEM helpers in the sike
+// package behind a keypair-shaped API (GenerateKey/Encapsulate/Decapsulate),
+// so a TLS-style caller doesn't have to hand-stitch the two KEMs or track
+// wire offsets itself.
+package hybrid
+
+import (
+	"crypto/ecdh"
+	"errors"
+	"io"
+
+	"boringssl.googlesource.com/boringssl.git/ssl/test/runner/sike"
+)
+
+// PrivateKey is a hybrid X25519+SIKE private key.
+type PrivateKey struct {
+	X25519 *ecdh.PrivateKey
+	SIKE   *sike.PrivateKey
+}
+
+// PublicKey is a hybrid X25519+SIKE public key.
+type PublicKey struct {
+	X25519 *ecdh.PublicKey
+	SIKE   *sike.PublicKey
+}
+
+// GenerateKey generates a fresh hybrid keypair on the given SIKE parameter
+// set.
+func GenerateKey(rng io.Reader, ps *sike.ParameterSet) (*PrivateKey, *PublicKey, error) {
+	x25519Priv, err := ecdh.X25519().GenerateKey(rng)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sikePriv := sike.NewPrivateKey(sike.KeyVariant_SIKE, ps)
+	if err := sikePriv.Generate(rng); err != nil {
+		return nil, nil, err
+	}
+
+	sikePub, err := sikePriv.GeneratePublicKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	priv := &PrivateKey{X25519: x25519Priv, SIKE: sikePriv}
+	pub := &PublicKey{X25519: x25519Priv.PublicKey(), SIKE: sikePub}
+	return priv, pub, nil
+}
+
+// Marshal encodes pub as the wire client key share: x25519_pub(32) ||
+// sike_pub.
+func (pub *PublicKey) Marshal() []byte {
+	out := make([]byte, 0, 32+pub.SIKE.Size())
+	out = append(out, pub.X25519.Bytes()...)
+	return append(out, pub.SIKE.Export()...)
+}
+
+// Unmarshal parses a wire client key share produced by Marshal, rejecting
+// malformed component lengths.
+func Unmarshal(ps *sike.ParameterSet, data []byte) (*PublicKey, error) {
+	if len(data) != sike.HybridPublicKeySize(ps) {
+		return nil, errors.New("hybrid: malformed public key")
+	}
+	x25519Pub, err := ecdh.X25519().NewPublicKey(data[:32])
+	if err != nil {
+		return nil, errors.New("hybrid: malformed x25519 component")
+	}
+	sikePub := sike.NewPublicKey(sike.KeyVariant_SIKE, ps)
+	if err := sikePub.Import(data[32:]); err != nil {
+		return nil, err
+	}
+	return &PublicKey{X25519: x25519Pub, SIKE: sikePub}, nil
+}
+
+// Encapsulate is the server side of the hybrid exchange: it returns the
+// wire-encoded response (x25519_pub || sike_ctext) for the client's key
+// share pub and the combined shared secret.
+func Encapsulate(rng io.Reader, ps *sike.ParameterSet, pub *PublicKey) (ctext, secret []byte, err error) {
+	return sike.HybridEncapsulate(rng, ps, pub.Marshal())
+}
+
+// Decapsulate is the client side: given the keypair GenerateKey produced and
+// the server's Encapsulate response, it recovers the combined shared
+// secret. Decapsulation is constant time for properly initialized input,
+// since it reduces directly to ecdh.PrivateKey.ECDH and sike.Decapsulate,
+// both of which are.
+func Decapsulate(priv *PrivateKey, pub *PublicKey, ctext []byte) ([]byte, error) {
+	if len(ctext) < 32 {
+		return nil, errors.New("hybrid: malformed ciphertext: x25519 component truncated")
+	}
+	return sike.HybridDecapsulate(priv.X25519, priv.SIKE, pub.SIKE, ctext)
+}
@@ -0,0 +1,44 @@
+package sike
+
+import "golang.org/x/crypto/sha3"
+
+// KdfMode selects the hash construction backing the SIKE/SIDH KDF and PRF
+// (the F/G/H functions in the spec). KdfModeCShake256 is the construction
+// mandated by the SIKE submission and is what every registered
+// ParameterSet (see paramset.go) uses. No registered ParameterSet selects
+// KdfModeHmacSha256, so the KEM and PKE functions of this package never
+// use it; it is reached only by calling Kdf with it directly.
+type KdfMode uint8
+
+const (
+	KdfModeHmacSha256 KdfMode = iota
+	KdfModeCShake256
+)
+
+// Kdf fills out with len(out) bytes derived from in, domain-separated by
+// the customization string S (one of G, H, F, or aeadKeyLabel in
+// stream.go), using the hash construction selected by mode. The callers in
+// this package take mode from the registered ParameterSet for the key's
+// prime (paramSetOf in paramset.go), so all keys on one prime share a
+// single construction.
+//
+// Unlike hashMac, out may be of arbitrary length: cSHAKE-256 is an XOF, so
+// larger parameter sets (p610, p751) can request more output without a
+// source change.
+func Kdf(mode KdfMode, out, in, S []byte) {
+	switch mode {
+	case KdfModeHmacSha256:
+		hashMac(out, in, S)
+	default:
+		cShake256(out, in, S)
+	}
+}
+
+// cShake256 squeezes len(out) bytes of cSHAKE-256(X=in, L=8*len(out), N="",
+// S=S) into out, per NIST SP 800-185. The customization string S is one of
+// the package-level G, H, F domain separators, or aeadKeyLabel (stream.go).
+func cShake256(out, in, S []byte) {
+	h := sha3.NewCShake256(nil, S)
+	h.Write(in)
+	h.Read(out)
+}
@@ -0,0 +1,78 @@
+package sike
+
+import "errors"
+
+// ParamID identifies a concrete SIKE/SIDH parameter set by its NIST
+// security level, matching the naming used by the SIKE submission.
+type ParamID uint8
+
+const (
+	SIKEp434 ParamID = iota
+	SIKEp503
+	SIKEp610
+	SIKEp751
+)
+
+// ParameterSet binds a ParamID to the field/curve constants it runs over.
+// NewPrivateKey and NewPublicKey take one of these instead of always
+// binding to the single package-level Params global. Only SIKEp503 is
+// registered in this build (see paramSets below), so it is the only
+// parameter set GetParameterSet returns; p434, p610 and p751 cannot be
+// selected.
+//
+// KdfMode names the KDF/PRF construction (see kdf.go) of a parameter set.
+// Keys store only *Params, not the ParameterSet they were built from:
+// Encrypt, Decrypt, Encapsulate, Decapsulate and the stream functions look
+// the mode up with paramSetOf, i.e. from the registered set whose Params.Id
+// matches the key's. A ParameterSet built by hand with another KdfMode does
+// not change the construction those functions use; with SIKEp503
+// registered as KdfModeCShake256, they always use cSHAKE-256.
+type ParameterSet struct {
+	ID      ParamID
+	Params  *Params
+	KdfMode KdfMode
+}
+
+// ErrParameterSetUnavailable is returned by GetParameterSet for an ID whose
+// field arithmetic backend isn't wired into this build yet.
+var ErrParameterSetUnavailable = errors.New("sidh: parameter set not available in this build")
+
+// paramSets holds the parameter sets this build actually has field
+// arithmetic for. SIKEp503 reuses the existing Params global (the only
+// prime this package has backed since before parameter-set selection
+// existed). arith.go's Fp/FpX2 and every field-arithmetic function are
+// hard-wired to p503's word count, sliding-window strategy tables, and
+// reduction constant, so SIKEp751/SIKEp434/SIKEp610 have no field
+// arithmetic backend in this tree at all and are intentionally left
+// unregistered; splitting the field layer out so a second prime can be
+// plugged in (e.g. an internal/arith package per prime) hasn't been done.
+var paramSets = map[ParamID]*ParameterSet{
+	SIKEp503: {ID: SIKEp503, Params: &Params, KdfMode: KdfModeCShake256},
+}
+
+// GetParameterSet looks up a ParameterSet by ID, for use with NewPrivateKey
+// / NewPublicKey.
+//
+// Only SIKEp503 is registered in this build (see paramSets above); calling
+// this with SIKEp434, SIKEp610, or SIKEp751 returns ErrParameterSetUnavailable,
+// not a zero ParameterSet, so callers negotiating a security level still
+// need to handle the "not available" case explicitly.
+func GetParameterSet(id ParamID) (*ParameterSet, error) {
+	ps, ok := paramSets[id]
+	if !ok {
+		return nil, ErrParameterSetUnavailable
+	}
+	return ps, nil
+}
+
+// paramSetOf returns the registered ParameterSet backing p, used internally
+// to recover a ParamID from a peer's *Params (e.g. to generate an ephemeral
+// key on the same parameter set as a public key we were just handed).
+func paramSetOf(p *Params) (*ParameterSet, error) {
+	for _, ps := range paramSets {
+		if ps.Params.Id == p.Id {
+			return ps, nil
+		}
+	}
+	return nil, ErrParameterSetUnavailable
+}
@@ -2,6 +2,7 @@ package sike
 
 import (
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"crypto/subtle"
 	"errors"
@@ -15,7 +16,9 @@ var G = []byte{0x00, 0x00}
 var H = []byte{0x01, 0x00}
 var F = []byte{0x02, 0x00}
 
-// Generates HMAC-SHA256 sum
+// Generates HMAC-SHA256 sum. Kept only so that KdfModeHmacSha256 can
+// reproduce pre-cSHAKE test vectors; new code should go through Kdf, which
+// defaults to the spec-mandated cSHAKE-256 construction (see kdf.go).
 func hashMac(out, in, S []byte) {
 	h := hmac.New(sha256.New, in)
 	h.Write(S)
@@ -37,36 +40,36 @@ func zeroize(fp *Fp2) {
 // Convert the input to wire format.
 //
 // The output byte slice must be at least 2*bytelen(p) bytes long.
-func convFp2ToBytes(output []byte, fp2 *Fp2) {
-	if len(output) < 2*Params.Bytelen {
+func convFp2ToBytes(params *Params, output []byte, fp2 *Fp2) {
+	if len(output) < 2*params.Bytelen {
 		panic("output byte slice too short")
 	}
 	var a Fp2
 	fromMontDomain(fp2, &a)
 
 	// convert to bytes in little endian form
-	for i := 0; i < Params.Bytelen; i++ {
+	for i := 0; i < params.Bytelen; i++ {
 		// set i = j*8 + k
 		tmp := i / 8
 		k := uint64(i % 8)
 		output[i] = byte(a.A[tmp] >> (8 * k))
-		output[i+Params.Bytelen] = byte(a.B[tmp] >> (8 * k))
+		output[i+params.Bytelen] = byte(a.B[tmp] >> (8 * k))
 	}
 }
 
 // Read 2*bytelen(p) bytes into the given ExtensionFieldElement.
 //
 // It is an error to call this function if the input byte slice is less than 2*bytelen(p) bytes long.
-func convBytesToFp2(fp2 *Fp2, input []byte) {
-	if len(input) < 2*Params.Bytelen {
+func convBytesToFp2(params *Params, fp2 *Fp2, input []byte) {
+	if len(input) < 2*params.Bytelen {
 		panic("input byte slice too short")
 	}
 
-	for i := 0; i < Params.Bytelen; i++ {
+	for i := 0; i < params.Bytelen; i++ {
 		j := i / 8
 		k := uint64(i % 8)
 		fp2.A[j] |= uint64(input[i]) << (8 * k)
-		fp2.B[j] |= uint64(input[i+Params.Bytelen]) << (8 * k)
+		fp2.B[j] |= uint64(input[i+params.Bytelen]) << (8 * k)
 	}
 	toMontDomain(fp2)
 }
@@ -220,13 +223,16 @@ func traverseTreeSharedKeyB(curve *ProjectiveCurveParameters, xR *ProjectivePoin
 }
 
 // Generate a public key in the 2-torsion group
-func publicKeyGenA(prv *PrivateKey) (pub *PublicKey) {
+func publicKeyGenA(prv *PrivateKey) (pub *PublicKey, err error) {
 	var xPA, xQA, xRA ProjectivePoint
 	var xPB, xQB, xRB, xR ProjectivePoint
-	var invZP, invZQ, invZR Fp2
 	var tmp ProjectiveCurveParameters
 
-	pub = NewPublicKey(KeyVariant_SIDH_A)
+	ps, err := paramSetOf(prv.params)
+	if err != nil {
+		return nil, err
+	}
+	pub = NewPublicKey(KeyVariant_SIDH_A, ps)
 	var phi = NewIsogeny4()
 
 	// Load points for A
@@ -253,22 +259,26 @@ func publicKeyGenA(prv *PrivateKey) (pub *PublicKey) {
 	xPA = phi.EvaluatePoint(&xPB)
 	xQA = phi.EvaluatePoint(&xQB)
 	xRA = phi.EvaluatePoint(&xRB)
-	Fp2Batch3Inv(&xPA.Z, &xQA.Z, &xRA.Z, &invZP, &invZQ, &invZR)
 
-	mul(&pub.affine_xP, &xPA.X, &invZP)
-	mul(&pub.affine_xQ, &xQA.X, &invZQ)
-	mul(&pub.affine_xQmP, &xRA.X, &invZR)
+	invZ := make([]Fp2, 3)
+	invBatch(invZ, []Fp2{xPA.Z, xQA.Z, xRA.Z})
+	mul(&pub.affine_xP, &xPA.X, &invZ[0])
+	mul(&pub.affine_xQ, &xQA.X, &invZ[1])
+	mul(&pub.affine_xQmP, &xRA.X, &invZ[2])
 	return
 }
 
 // Generate a public key in the 3-torsion group
-func publicKeyGenB(prv *PrivateKey) (pub *PublicKey) {
+func publicKeyGenB(prv *PrivateKey) (pub *PublicKey, err error) {
 	var xPB, xQB, xRB, xR ProjectivePoint
 	var xPA, xQA, xRA ProjectivePoint
-	var invZP, invZQ, invZR Fp2
 	var tmp ProjectiveCurveParameters
 
-	pub = NewPublicKey(prv.keyVariant)
+	ps, err := paramSetOf(prv.params)
+	if err != nil {
+		return nil, err
+	}
+	pub = NewPublicKey(prv.keyVariant, ps)
 	var phi = NewIsogeny3()
 
 	// Load points for B
@@ -292,11 +302,12 @@ func publicKeyGenB(prv *PrivateKey) (pub *PublicKey) {
 	xPB = phi.EvaluatePoint(&xPA)
 	xQB = phi.EvaluatePoint(&xQA)
 	xRB = phi.EvaluatePoint(&xRA)
-	Fp2Batch3Inv(&xPB.Z, &xQB.Z, &xRB.Z, &invZP, &invZQ, &invZR)
 
-	mul(&pub.affine_xP, &xPB.X, &invZP)
-	mul(&pub.affine_xQ, &xQB.X, &invZQ)
-	mul(&pub.affine_xQmP, &xRB.X, &invZR)
+	invZ := make([]Fp2, 3)
+	invBatch(invZ, []Fp2{xPB.Z, xQB.Z, xRB.Z})
+	mul(&pub.affine_xP, &xPB.X, &invZ[0])
+	mul(&pub.affine_xQ, &xQB.X, &invZ[1])
+	mul(&pub.affine_xQmP, &xRB.X, &invZ[2])
 	return
 }
 
@@ -330,7 +341,7 @@ func deriveSecretA(prv *PrivateKey, pub *PublicKey) []byte {
 	c := phi.GenerateCurve(&xR)
 	RecoverCurveCoefficients4(&cparam, &c)
 	Jinvariant(&cparam, &jInv)
-	convFp2ToBytes(sharedSecret, &jInv)
+	convFp2ToBytes(pub.params, sharedSecret, &jInv)
 	return sharedSecret
 }
 
@@ -360,38 +371,43 @@ func deriveSecretB(prv *PrivateKey, pub *PublicKey) []byte {
 	c := phi.GenerateCurve(&xR)
 	RecoverCurveCoefficients3(&cparam, &c)
 	Jinvariant(&cparam, &jInv)
-	convFp2ToBytes(sharedSecret, &jInv)
+	convFp2ToBytes(pub.params, sharedSecret, &jInv)
 	return sharedSecret
 }
 
 func encrypt(skA *PrivateKey, pkA, pkB *PublicKey, ptext []byte) ([]byte, error) {
-	var n [40]byte // n can is max 320-bit (see 1.4 of [SIKE])
 	var ptextLen = len(ptext)
+	n := make([]byte, ptextLen)
 
 	if pkB.keyVariant != KeyVariant_SIKE {
 		return nil, errors.New("wrong key type")
 	}
 
+	ps, err := paramSetOf(pkB.params)
+	if err != nil {
+		return nil, err
+	}
+
 	j, err := DeriveSecret(skA, pkB)
 	if err != nil {
 		return nil, err
 	}
 
-	hashMac(n[:ptextLen], j, F)
+	Kdf(ps.KdfMode, n, j, F)
 	for i, _ := range ptext {
 		n[i] ^= ptext[i]
 	}
 
 	ret := make([]byte, pkA.Size()+ptextLen)
 	copy(ret, pkA.Export())
-	copy(ret[pkA.Size():], n[:ptextLen])
+	copy(ret[pkA.Size():], n)
 	return ret, nil
 }
 
-// NewPrivateKey initializes private key.
+// NewPrivateKey initializes private key on the given parameter set.
 // Usage of this function guarantees that the object is correctly initialized.
-func NewPrivateKey(v KeyVariant) *PrivateKey {
-	prv := &PrivateKey{key: key{params: &Params, keyVariant: v}}
+func NewPrivateKey(v KeyVariant, ps *ParameterSet) *PrivateKey {
+	prv := &PrivateKey{key: key{params: ps.Params, keyVariant: v}}
 	if (v & KeyVariant_SIDH_A) == KeyVariant_SIDH_A {
 		prv.Scalar = make([]byte, prv.params.A.SecretByteLen)
 	} else {
@@ -403,10 +419,10 @@ func NewPrivateKey(v KeyVariant) *PrivateKey {
 	return prv
 }
 
-// NewPublicKey initializes public key.
+// NewPublicKey initializes public key on the given parameter set.
 // Usage of this function guarantees that the object is correctly initialized.
-func NewPublicKey(v KeyVariant) *PublicKey {
-	return &PublicKey{key: key{params: &Params, keyVariant: v}}
+func NewPublicKey(v KeyVariant, ps *ParameterSet) *PublicKey {
+	return &PublicKey{key: key{params: ps.Params, keyVariant: v}}
 }
 
 // Import clears content of the public key currently stored in the structure
@@ -417,9 +433,9 @@ func (pub *PublicKey) Import(input []byte) error {
 		return errors.New("sidh: input to short")
 	}
 	ssSz := pub.params.SharedSecretSize
-	convBytesToFp2(&pub.affine_xP, input[0:ssSz])
-	convBytesToFp2(&pub.affine_xQ, input[ssSz:2*ssSz])
-	convBytesToFp2(&pub.affine_xQmP, input[2*ssSz:3*ssSz])
+	convBytesToFp2(pub.params, &pub.affine_xP, input[0:ssSz])
+	convBytesToFp2(pub.params, &pub.affine_xQ, input[ssSz:2*ssSz])
+	convBytesToFp2(pub.params, &pub.affine_xQmP, input[2*ssSz:3*ssSz])
 	return nil
 }
 
@@ -428,9 +444,9 @@ func (pub *PublicKey) Import(input []byte) error {
 func (pub *PublicKey) Export() []byte {
 	output := make([]byte, pub.params.PublicKeySize)
 	ssSz := pub.params.SharedSecretSize
-	convFp2ToBytes(output[0:ssSz], &pub.affine_xP)
-	convFp2ToBytes(output[ssSz:2*ssSz], &pub.affine_xQ)
-	convFp2ToBytes(output[2*ssSz:3*ssSz], &pub.affine_xQmP)
+	convFp2ToBytes(pub.params, output[0:ssSz], &pub.affine_xP)
+	convFp2ToBytes(pub.params, output[ssSz:2*ssSz], &pub.affine_xQ)
+	convFp2ToBytes(pub.params, output[2*ssSz:3*ssSz], &pub.affine_xQmP)
 	return output
 }
 
@@ -509,10 +525,13 @@ func (prv *PrivateKey) Generate(rand io.Reader) error {
 	return err
 }
 
-// Generates public key.
+// Generates public key. Returns an error if prv.params isn't backed by a
+// registered ParameterSet (see paramSetOf in paramset.go); this can only
+// happen if prv was built from a *ParameterSet that didn't come from
+// GetParameterSet.
 //
 // Constant time.
-func (prv *PrivateKey) GeneratePublicKey() *PublicKey {
+func (prv *PrivateKey) GeneratePublicKey() (*PublicKey, error) {
 	if (prv.keyVariant & KeyVariant_SIDH_A) == KeyVariant_SIDH_A {
 		return publicKeyGenA(prv)
 	}
@@ -529,7 +548,15 @@ func (prv *PrivateKey) GeneratePublicKey() *PublicKey {
 // Function may return error. This happens only in case provided input is invalid.
 // Constant time for properly initialized private and public key.
 func DeriveSecret(prv *PrivateKey, pub *PublicKey) ([]byte, error) {
+	return deriveSecret(prv, pub, true)
+}
 
+// deriveSecret is DeriveSecret with an explicit switch for public-key
+// validation. validate is true for the raw SIDH entry point (DeriveSecret)
+// and false on the Decapsulate call path, where the Fujisaki-Okamoto
+// re-encryption check already binds the ciphertext to a single public key
+// and re-validating here would only cost cycles (see validate.go).
+func deriveSecret(prv *PrivateKey, pub *PublicKey, validate bool) ([]byte, error) {
 	if (pub == nil) || (prv == nil) {
 		return nil, errors.New("sidh: invalid arguments")
 	}
@@ -538,6 +565,19 @@ func DeriveSecret(prv *PrivateKey, pub *PublicKey) ([]byte, error) {
 		return nil, errors.New("sidh: public and private are incompatbile")
 	}
 
+	if validate {
+		if err := ValidatePublicKey(pub); err != nil {
+			// Don't report the failure directly: that would let an active
+			// attacker use DeriveSecret as a validity oracle (GPST). Return
+			// a secret indistinguishable from a real one instead.
+			secret := make([]byte, pub.params.SharedSecretSize)
+			if _, rerr := io.ReadFull(rand.Reader, secret); rerr != nil {
+				return nil, rerr
+			}
+			return secret, nil
+		}
+	}
+
 	if (prv.keyVariant & KeyVariant_SIDH_A) == KeyVariant_SIDH_A {
 		return deriveSecretA(prv, pub), nil
 	} else {
@@ -555,13 +595,20 @@ func Encrypt(rng io.Reader, pub *PublicKey, ptext []byte) ([]byte, error) {
 		return nil, errors.New("Unsupported message length")
 	}
 
-	skA := NewPrivateKey(KeyVariant_SIDH_A)
-	err := skA.Generate(rng)
+	ps, err := paramSetOf(pub.params)
+	if err != nil {
+		return nil, err
+	}
+	skA := NewPrivateKey(KeyVariant_SIDH_A, ps)
+	err = skA.Generate(rng)
 	if err != nil {
 		return nil, err
 	}
 
-	pkA := skA.GeneratePublicKey()
+	pkA, err := skA.GeneratePublicKey()
+	if err != nil {
+		return nil, err
+	}
 	return encrypt(skA, pkA, pub, ptext)
 }
 
@@ -569,7 +616,12 @@ func Encrypt(rng io.Reader, pub *PublicKey, ptext []byte) ([]byte, error) {
 // decryption succeeds or error in case unexptected input was provided.
 // Constant time
 func Decrypt(prv *PrivateKey, ctext []byte) ([]byte, error) {
-	var n [40]byte // n can is max 320-bit (see 1.4 of [SIKE])
+	return decrypt(prv, ctext, true)
+}
+
+// decrypt is Decrypt with an explicit switch for public-key validation; see
+// deriveSecret for why Decapsulate calls this with validate=false.
+func decrypt(prv *PrivateKey, ctext []byte, validate bool) ([]byte, error) {
 	var c1_len int
 	var pk_len = prv.params.PublicKeySize
 
@@ -583,22 +635,27 @@ func Decrypt(prv *PrivateKey, ctext []byte) ([]byte, error) {
 	if c1_len != (int(prv.params.KemSize) + 8) {
 		return nil, errors.New("wrong size of cipher text")
 	}
+	n := make([]byte, c1_len)
 
-	c0 := NewPublicKey(KeyVariant_SIDH_A)
-	err := c0.Import(ctext[:pk_len])
+	ps, err := paramSetOf(prv.params)
 	if err != nil {
 		return nil, err
 	}
-	j, err := DeriveSecret(prv, c0)
+	c0 := NewPublicKey(KeyVariant_SIDH_A, ps)
+	err = c0.Import(ctext[:pk_len])
+	if err != nil {
+		return nil, err
+	}
+	j, err := deriveSecret(prv, c0, validate)
 	if err != nil {
 		return nil, err
 	}
 
-	hashMac(n[:c1_len], j, F)
-	for i, _ := range n[:c1_len] {
+	Kdf(ps.KdfMode, n, j, F)
+	for i, _ := range n {
 		n[i] ^= ctext[pk_len+i]
 	}
-	return n[:c1_len], nil
+	return n, nil
 }
 
 // Encapsulation receives the public key and generates SIKE ciphertext and shared secret.
@@ -613,6 +670,11 @@ func Encapsulate(rng io.Reader, pub *PublicKey) (ctext []byte, secret []byte, er
 	// Resulting shared secret
 	secret = make([]byte, pub.params.KemSize)
 
+	ps, err := paramSetOf(pub.params)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Generate ephemeral value
 	_, err = io.ReadFull(rng, ptext)
 	if err != nil {
@@ -620,21 +682,24 @@ func Encapsulate(rng io.Reader, pub *PublicKey) (ctext []byte, secret []byte, er
 	}
 
 	// must be big enough to store ptext+c0+c1
-	var hmac_key = make([]byte, pub.Size()+2*Params.MsgLen)
+	var hmac_key = make([]byte, pub.Size()+2*pub.params.MsgLen)
 	copy(hmac_key, ptext)
 	copy(hmac_key[len(ptext):], pub.Export())
-	hashMac(r, hmac_key[:len(ptext)+pub.Size()], G)
+	Kdf(ps.KdfMode, r, hmac_key[:len(ptext)+pub.Size()], G)
 	// Ensure bitlength is not bigger then to 2^e2-1
 	r[len(r)-1] &= (1 << (pub.params.A.SecretBitLen % 8)) - 1
 
 	// (c0 || c1) = Enc(pkA, ptext; r)
-	skA := NewPrivateKey(KeyVariant_SIDH_A)
+	skA := NewPrivateKey(KeyVariant_SIDH_A, ps)
 	err = skA.Import(r)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	pkA := skA.GeneratePublicKey()
+	pkA, err := skA.GeneratePublicKey()
+	if err != nil {
+		return nil, nil, err
+	}
 	ctext, err = encrypt(skA, pkA, pub, ptext)
 	if err != nil {
 		return nil, nil, err
@@ -643,7 +708,7 @@ func Encapsulate(rng io.Reader, pub *PublicKey) (ctext []byte, secret []byte, er
 	// K = H(ptext||(c0||c1))
 	copy(hmac_key, ptext)
 	copy(hmac_key[len(ptext):], ctext)
-	hashMac(secret, hmac_key[:len(ptext)+len(ctext)], H)
+	Kdf(ps.KdfMode, secret, hmac_key[:len(ptext)+len(ctext)], H)
 	return ctext, secret, nil
 }
 
@@ -655,26 +720,32 @@ func Decapsulate(prv *PrivateKey, pub *PublicKey, ctext []byte) ([]byte, error)
 	var r = make([]byte, pub.params.A.SecretByteLen)
 	// Resulting shared secret
 	var secret = make([]byte, pub.params.KemSize)
-	var skA = NewPrivateKey(KeyVariant_SIDH_A)
+	ps, err := paramSetOf(pub.params)
+	if err != nil {
+		return nil, err
+	}
+	var skA = NewPrivateKey(KeyVariant_SIDH_A, ps)
 
-	m, err := Decrypt(prv, ctext)
+	m, err := decrypt(prv, ctext, false)
 	if err != nil {
 		return nil, err
 	}
 
 	// r' = G(m'||pub)
-	var hmac_key = make([]byte, pub.Size()+2*Params.MsgLen)
+	var hmac_key = make([]byte, pub.Size()+2*pub.params.MsgLen)
 	copy(hmac_key, m)
 	copy(hmac_key[len(m):], pub.Export())
-	hashMac(r, hmac_key[:len(m)+pub.Size()], G)
+	Kdf(ps.KdfMode, r, hmac_key[:len(m)+pub.Size()], G)
 	// Ensure bitlength is not bigger than 2^e2-1
 	r[len(r)-1] &= (1 << (pub.params.A.SecretBitLen % 8)) - 1
 
 	// Never fails
 	skA.Import(r)
 
-	// Never fails
-	pkA := skA.GeneratePublicKey()
+	// Never fails: skA was built from ps, a ParameterSet that GetParameterSet
+	// (or paramSetOf, above) already resolved, so paramSetOf(skA.params) can't
+	// fail the way a key built by hand from an unregistered *Params could.
+	pkA, _ := skA.GeneratePublicKey()
 	c0 := pkA.Export()
 
 	if subtle.ConstantTimeCompare(c0, ctext[:len(c0)]) == 1 {
@@ -690,6 +761,6 @@ func Decapsulate(prv *PrivateKey, pub *PublicKey, ctext []byte) ([]byte, error)
 		copy(hmac_key, prv.S)
 	}
 	copy(hmac_key[len(m):], ctext)
-	hashMac(secret, hmac_key[:len(m)+len(ctext)], H)
+	Kdf(ps.KdfMode, secret, hmac_key[:len(m)+len(ctext)], H)
 	return secret, nil
 }
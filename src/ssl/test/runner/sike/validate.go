@@ -0,0 +1,175 @@
+package sike
+
+import "errors"
+
+// ValidatePublicKey checks that pub is a well-formed SIDH public key,
+// guarding against the Galbraith-Petit-Shani-Ti (GPST) adaptive key-reuse
+// attack: an active attacker who can query DeriveSecret on chosen,
+// malformed public keys against a static private key can otherwise recover
+// that private key bit by bit (Galbraith, Petit, Shani, Ti, "On the
+// Security of Supersingular Isogeny Cryptosystems", ASIACRYPT 2016).
+//
+// It is only meaningful against raw SIDH (via DeriveSecret); it is not
+// needed, and not used, on the SIKE Decapsulate path, since the
+// Fujisaki-Okamoto re-encryption check there already binds the ciphertext
+// to a single valid public key.
+func ValidatePublicKey(pub *PublicKey) error {
+	var cparam ProjectiveCurveParameters
+	cparam.C = pub.params.OneFp2
+	RecoverCoordinateA(&cparam, &pub.affine_xP, &pub.affine_xQ, &pub.affine_xQmP)
+
+	// (a) xP, xQ and xQmP must lie on the curve the coefficients were just
+	// recovered from.
+	if !isOnCurve(&cparam, &pub.affine_xP) ||
+		!isOnCurve(&cparam, &pub.affine_xQ) ||
+		!isOnCurve(&cparam, &pub.affine_xQmP) {
+		return errors.New("sidh: public key points do not lie on the claimed curve")
+	}
+
+	// pub carries the *complementary* torsion basis: a SIDH_A public key was
+	// produced by publicKeyGenA walking the 2^e2 isogeny, which evaluates
+	// the 3-torsion (B) basis points; a SIDH_B/SIKE public key walks the
+	// 3^e3 isogeny and evaluates the 2-torsion (A) basis points. So the
+	// torsion side to check here is driven by the walk side (the keyVariant
+	// bit), not the basis it happens to share a name with.
+	isThreeTorsion := (pub.keyVariant & KeyVariant_SIDH_A) == KeyVariant_SIDH_A
+	// e is the torsion exponent (e2 or e3). A.SecretBitLen is e2, but
+	// B.SecretBitLen is floor(log_2(3^e3)), not e3; e3 is the number of
+	// 3-isogenies in the B walk: one per IsogenyStrategy entry plus the
+	// final one.
+	var e uint
+	if isThreeTorsion {
+		e = uint(len(pub.params.B.IsogenyStrategy)) + 1
+	} else {
+		e = pub.params.A.SecretBitLen
+	}
+
+	// (b) P and Q must each generate the full 2-power (resp. 3-power)
+	// torsion subgroup: one fewer doubling/tripling than the subgroup order
+	// must not collapse either point to the identity, and the two
+	// full-order points must be distinct (otherwise P and Q don't form a
+	// basis and every subsequent isogeny walk degenerates).
+	xP := ProjectivePoint{X: pub.affine_xP, Z: pub.params.OneFp2}
+	xQ := ProjectivePoint{X: pub.affine_xQ, Z: pub.params.OneFp2}
+	lastP, okP := lastNonIdentity(&cparam, &xP, e, isThreeTorsion)
+	lastQ, okQ := lastNonIdentity(&cparam, &xQ, e, isThreeTorsion)
+	if !okP || !okQ {
+		return errors.New("sidh: public key point does not generate the full torsion subgroup")
+	}
+	if samePoint(&lastP, &lastQ) {
+		return errors.New("sidh: public key points P and Q do not form a basis")
+	}
+
+	// (c) xQmP must generate the full torsion subgroup too, the same way P
+	// and Q do above; a zero or low-order xQmP would degenerate the basis
+	// ScalarMul3Pt walks.
+	//
+	// This package only ever carries x-only ProjectivePoint{X, Z} values -
+	// there is no y-coordinate or point-addition code anywhere in this
+	// tree - so there is no way to evaluate a real Weil/Tate pairing here
+	// to additionally bind xQmP to "the" x(Q-P) for this specific P and Q,
+	// as opposed to some other point of the same order. A prior version of
+	// this check called a MillerLoop function that does not exist in this
+	// package for exactly that reason. Closing that gap needs either a
+	// full-point (affine/y-coordinate) representation alongside the x-only
+	// one, or the Montgomery x-only consistency relation among x(P), x(Q),
+	// x(Q-P); neither is implemented in this tree, so this validator is
+	// weaker than the GPST countermeasure described in CIRCL/SIKE reference
+	// code and should not be relied on as a full substitute for it.
+	xQmP := ProjectivePoint{X: pub.affine_xQmP, Z: pub.params.OneFp2}
+	if _, ok := lastNonIdentity(&cparam, &xQmP, e, isThreeTorsion); !ok {
+		return errors.New("sidh: xQmP does not generate the full torsion subgroup")
+	}
+
+	return nil
+}
+
+// isOnCurve reports whether x is the x-coordinate of some point on the
+// Montgomery curve By^2 = x^3 + Ax^2 + x, i.e. whether x^3 + Ax^2 + x is a
+// quadratic residue mod p.
+func isOnCurve(curve *ProjectiveCurveParameters, x *Fp2) bool {
+	var a, rhs, xSq, xCube Fp2
+	invC := curve.C
+	inv(&invC, &invC)
+	mul(&a, &curve.A, &invC)
+
+	sqr(&xSq, x)
+	mul(&xCube, &xSq, x)
+	add(&rhs, &xCube, x)
+
+	var ax2 Fp2
+	mul(&ax2, &a, &xSq)
+	add(&rhs, &rhs, &ax2)
+
+	return isQuadraticResidue(&rhs)
+}
+
+// lastNonIdentity steps x e-1 doublings (resp. triplings), one fewer than
+// the subgroup order 2^e (resp. 3^e), and reports the resulting point along
+// with whether it is non-identity, i.e. whether x generates the full
+// torsion subgroup.
+func lastNonIdentity(curve *ProjectiveCurveParameters, x *ProjectivePoint, e uint, threeTorsion bool) (ProjectivePoint, bool) {
+	pt := *x
+	if threeTorsion {
+		cparam := CalcCurveParamsEquiv3(curve)
+		for i := uint(1); i < e; i++ {
+			Pow3k(&pt, &cparam, 1)
+		}
+	} else {
+		cparam := CalcCurveParamsEquiv4(curve)
+		for i := uint(1); i < e; i++ {
+			Pow2k(&pt, &cparam, 1)
+		}
+	}
+	return pt, !isIdentity(&pt)
+}
+
+// samePoint reports whether two projective points represent the same
+// affine point, i.e. their x-coordinates agree once normalized to Z=1.
+func samePoint(p, q *ProjectivePoint) bool {
+	var lhs, rhs Fp2
+	mul(&lhs, &p.X, &q.Z)
+	mul(&rhs, &q.X, &p.Z)
+	// mul leaves results reduced only mod 2*p; reduce to [0, p) first.
+	fpRdcP(&lhs.A)
+	fpRdcP(&lhs.B)
+	fpRdcP(&rhs.A)
+	fpRdcP(&rhs.B)
+	return lhs == rhs
+}
+
+// isIdentity reports whether pt is the point at infinity (Z == 0).
+func isIdentity(pt *ProjectivePoint) bool {
+	// Z is only reduced mod 2*p; reduce to [0, p) before comparing.
+	z := pt.Z
+	fpRdcP(&z.A)
+	fpRdcP(&z.B)
+	var zero Fp2
+	return z == zero
+}
+
+// isQuadraticResidue reports whether x is a square mod p. Uses the fact that
+// for Fp2 = Fp[i] with p = 3 mod 4, x is a square in Fp2 iff its norm
+// N(x) = a^2+b^2 is a square in Fp, which Euler's criterion (via the same
+// x^((p-3)/4) ladder p34 uses for inversion) tests directly in Fp.
+func isQuadraticResidue(x *Fp2) bool {
+	var norm Fp
+	var asq, bsq FpX2
+	fpMul(&asq, &x.A, &x.A)
+	fpMul(&bsq, &x.B, &x.B)
+	fp2Add(&asq, &asq, &bsq)
+	fpMontRdc(&norm, &asq)
+
+	var t Fp
+	p34(&t, &norm)
+	fpMulRdc(&t, &t, &t)
+	fpMulRdc(&t, &t, &norm)
+
+	var one Fp2
+	one.A[0] = 1
+	toMontDomain(&one)
+	// Both sides are only reduced mod 2*p; reduce to [0, p) first.
+	fpRdcP(&t)
+	fpRdcP(&one.A)
+	return t == one.A
+}